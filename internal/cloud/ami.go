@@ -0,0 +1,133 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// AMIResolver resolves an os_family + architecture pair to a concrete AMI ID
+// via the AWS-published SSM public parameters, so fleets don't need a
+// hardcoded, manually-updated AMI map. It is safe for concurrent use and
+// caches both SSM clients and resolved AMI IDs per region for the process
+// lifetime.
+type AMIResolver struct {
+	mu      sync.Mutex
+	clients map[string]*ssm.Client // region -> SSM client
+	cache   map[string]string      // region/osFamily/architecture -> AMI ID
+}
+
+// NewAMIResolver returns a resolver with empty caches.
+func NewAMIResolver() *AMIResolver {
+	return &AMIResolver{
+		clients: map[string]*ssm.Client{},
+		cache:   map[string]string{},
+	}
+}
+
+// Resolve returns the latest AMI ID published for osFamily/architecture in
+// region. architecture defaults to "x86_64" if empty.
+func (r *AMIResolver) Resolve(ctx context.Context, region, osFamily, architecture string) (string, error) {
+	if architecture == "" {
+		architecture = "x86_64"
+	}
+
+	key := region + "/" + osFamily + "/" + architecture
+
+	r.mu.Lock()
+	if ami, ok := r.cache[key]; ok {
+		r.mu.Unlock()
+		return ami, nil
+	}
+	r.mu.Unlock()
+
+	paramName, err := ssmParameterName(osFamily, architecture)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := r.clientForRegion(ctx, region)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(paramName)})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve AMI for %s/%s in %s: %v", osFamily, architecture, region, err)
+	}
+	if result.Parameter == nil || result.Parameter.Value == nil {
+		return "", fmt.Errorf("SSM parameter %s returned no value", paramName)
+	}
+	ami := *result.Parameter.Value
+
+	r.mu.Lock()
+	r.cache[key] = ami
+	r.mu.Unlock()
+
+	return ami, nil
+}
+
+func (r *AMIResolver) clientForRegion(ctx context.Context, region string) (*ssm.Client, error) {
+	r.mu.Lock()
+	if client, ok := r.clients[region]; ok {
+		r.mu.Unlock()
+		return client, nil
+	}
+	r.mu.Unlock()
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config for %s: %v", region, err)
+	}
+	client := ssm.NewFromConfig(awsCfg)
+
+	r.mu.Lock()
+	r.clients[region] = client
+	r.mu.Unlock()
+
+	return client, nil
+}
+
+// ssmParameterName maps an os_family + architecture pair to the AWS-published
+// SSM public parameter path that resolves to its latest AMI ID.
+func ssmParameterName(osFamily, architecture string) (string, error) {
+	switch osFamily {
+	case "amazon-linux-2":
+		switch architecture {
+		case "x86_64":
+			return "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2", nil
+		case "arm64":
+			return "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-arm64-gp2", nil
+		}
+	case "amazon-linux-2023":
+		switch architecture {
+		case "x86_64":
+			return "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64", nil
+		case "arm64":
+			return "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64", nil
+		}
+	case "ubuntu-22.04":
+		switch architecture {
+		case "x86_64":
+			return "/aws/service/canonical/ubuntu/server/22.04/stable/current/amd64/hvm/ebs-gp2/ami-id", nil
+		case "arm64":
+			return "/aws/service/canonical/ubuntu/server/22.04/stable/current/arm64/hvm/ebs-gp2/ami-id", nil
+		}
+	case "debian-12":
+		switch architecture {
+		case "x86_64":
+			return "/aws/service/debian/release/12/latest/amd64", nil
+		case "arm64":
+			return "/aws/service/debian/release/12/latest/arm64", nil
+		}
+	case "windows-2022":
+		if architecture == "x86_64" {
+			return "/aws/service/ami-windows-latest/Windows_Server-2022-English-Full-Base", nil
+		}
+	}
+	return "", fmt.Errorf("no SSM AMI parameter known for os_family %q architecture %q", osFamily, architecture)
+}
@@ -0,0 +1,38 @@
+package cloud
+
+import "testing"
+
+func TestSSMParameterName(t *testing.T) {
+	tests := []struct {
+		osFamily     string
+		architecture string
+		want         string
+	}{
+		{"amazon-linux-2", "x86_64", "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2"},
+		{"amazon-linux-2", "arm64", "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-arm64-gp2"},
+		{"amazon-linux-2023", "x86_64", "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"},
+		{"ubuntu-22.04", "arm64", "/aws/service/canonical/ubuntu/server/22.04/stable/current/arm64/hvm/ebs-gp2/ami-id"},
+		{"debian-12", "x86_64", "/aws/service/debian/release/12/latest/amd64"},
+		{"windows-2022", "x86_64", "/aws/service/ami-windows-latest/Windows_Server-2022-English-Full-Base"},
+	}
+
+	for _, tt := range tests {
+		got, err := ssmParameterName(tt.osFamily, tt.architecture)
+		if err != nil {
+			t.Errorf("ssmParameterName(%q, %q) returned error: %v", tt.osFamily, tt.architecture, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ssmParameterName(%q, %q) = %q, want %q", tt.osFamily, tt.architecture, got, tt.want)
+		}
+	}
+}
+
+func TestSSMParameterNameUnknownCombination(t *testing.T) {
+	if _, err := ssmParameterName("windows-2022", "arm64"); err == nil {
+		t.Error("ssmParameterName(windows-2022, arm64): want error, got nil")
+	}
+	if _, err := ssmParameterName("solaris-11", "x86_64"); err == nil {
+		t.Error("ssmParameterName(solaris-11, x86_64): want error, got nil")
+	}
+}
@@ -0,0 +1,171 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// AWSVMClient implements VMClient against a real EC2 client.
+type AWSVMClient struct {
+	ec2 *ec2.Client
+}
+
+// NewAWSVMClient wraps an existing ec2.Client.
+func NewAWSVMClient(client *ec2.Client) *AWSVMClient {
+	return &AWSVMClient{ec2: client}
+}
+
+func (c *AWSVMClient) RunInstance(ctx context.Context, input RunInstanceInput) (*Instance, error) {
+	if input.SubnetID == "" {
+		return nil, fmt.Errorf("subnet ID is required")
+	}
+	if len(input.VPCSecurityGroupIDs) == 0 {
+		return nil, fmt.Errorf("VPC security group IDs are required")
+	}
+	if input.AMI == "" {
+		return nil, fmt.Errorf("AMI is required")
+	}
+
+	tags := convertTags(input.Tags)
+	tags = append(tags, types.Tag{
+		Key:   aws.String("Name"),
+		Value: aws.String(input.Name),
+	})
+
+	runInput := &ec2.RunInstancesInput{
+		ImageId:      aws.String(input.AMI),
+		InstanceType: types.InstanceType(input.InstanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		KeyName:      aws.String(input.KeyName),
+		Monitoring: &types.RunInstancesMonitoringEnabled{
+			Enabled: aws.Bool(input.Monitoring),
+		},
+		NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{
+			{
+				DeviceIndex:              aws.Int32(0),
+				SubnetId:                 aws.String(input.SubnetID),
+				Groups:                   input.VPCSecurityGroupIDs,
+				AssociatePublicIpAddress: aws.Bool(true),
+			},
+		},
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         tags,
+			},
+		},
+	}
+
+	result, err := c.ec2.RunInstances(ctx, runInput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run instance: %v", err)
+	}
+	if len(result.Instances) == 0 {
+		return nil, fmt.Errorf("RunInstances returned no instances")
+	}
+
+	return toInstance(result.Instances[0]), nil
+}
+
+func (c *AWSVMClient) DescribeInstancesByTag(ctx context.Context, name string) ([]Instance, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []string{name},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"running", "pending", "stopping", "stopped"},
+			},
+		},
+	}
+
+	result, err := c.ec2.DescribeInstances(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instances: %v", err)
+	}
+
+	var instances []Instance
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			for _, tag := range instance.Tags {
+				if *tag.Key == "Name" && *tag.Value == name {
+					instances = append(instances, *toInstance(instance))
+					break
+				}
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+func (c *AWSVMClient) TerminateInstances(ctx context.Context, instanceIDs []string) error {
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	_, err := c.ec2.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: instanceIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to terminate instances: %v", err)
+	}
+
+	return nil
+}
+
+func (c *AWSVMClient) WaitUntilRunning(ctx context.Context, instanceID string) error {
+	waiter := ec2.NewInstanceRunningWaiter(c.ec2)
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, 5*time.Minute)
+	if err != nil {
+		return fmt.Errorf("instance %s did not reach running state: %v", instanceID, err)
+	}
+	return nil
+}
+
+func toInstance(instance types.Instance) *Instance {
+	out := &Instance{
+		ID:    aws.ToString(instance.InstanceId),
+		State: string(instance.State.Name),
+		Tags:  map[string]string{},
+	}
+	if instance.InstanceType != "" {
+		out.InstanceType = string(instance.InstanceType)
+	}
+	out.ImageID = aws.ToString(instance.ImageId)
+	out.KeyName = aws.ToString(instance.KeyName)
+	if instance.SubnetId != nil {
+		out.SubnetID = aws.ToString(instance.SubnetId)
+	}
+	if instance.LaunchTime != nil {
+		out.LaunchTime = *instance.LaunchTime
+	}
+	for _, sg := range instance.SecurityGroups {
+		out.SecurityGroupIDs = append(out.SecurityGroupIDs, aws.ToString(sg.GroupId))
+	}
+	for _, tag := range instance.Tags {
+		out.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return out
+}
+
+func convertTags(tags map[string]string) []types.Tag {
+	var result []types.Tag
+	for key, value := range tags {
+		result = append(result, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+	return result
+}
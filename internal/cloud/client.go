@@ -0,0 +1,49 @@
+// Package cloud defines a provider-agnostic interface for managing virtual
+// machine instances, so that reconciliation logic in package main can be
+// exercised without talking to real AWS.
+package cloud
+
+import (
+	"context"
+	"time"
+)
+
+// Instance is a provider-agnostic view of a running (or terminated) VM.
+type Instance struct {
+	ID               string
+	State            string
+	InstanceType     string
+	ImageID          string
+	KeyName          string
+	SubnetID         string
+	SecurityGroupIDs []string
+	Tags             map[string]string
+	LaunchTime       time.Time
+}
+
+// RunInstanceInput describes the VM to launch.
+type RunInstanceInput struct {
+	Name                string
+	InstanceType        string
+	AMI                 string
+	KeyName             string
+	SubnetID            string
+	VPCSecurityGroupIDs []string
+	Monitoring          bool
+	Tags                map[string]string
+}
+
+// VMClient abstracts the subset of EC2 operations the reconciler needs.
+// AWSVMClient implements it against real EC2; FakeVMClient implements it
+// in memory for tests.
+type VMClient interface {
+	// RunInstance launches a single instance and returns its initial state.
+	RunInstance(ctx context.Context, input RunInstanceInput) (*Instance, error)
+	// DescribeInstancesByTag returns non-terminated instances whose "Name"
+	// tag equals name.
+	DescribeInstancesByTag(ctx context.Context, name string) ([]Instance, error)
+	// TerminateInstances requests termination of the given instance IDs.
+	TerminateInstances(ctx context.Context, instanceIDs []string) error
+	// WaitUntilRunning blocks until instanceID reaches the "running" state.
+	WaitUntilRunning(ctx context.Context, instanceID string) error
+}
@@ -0,0 +1,141 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeVMClient is an in-memory VMClient for exercising reconciliation logic
+// without AWS. Instances live in a map keyed by instance ID; RunInstance,
+// TerminateInstances and WaitUntilRunning can be made to sleep or fail via
+// the Delay and Fault* fields so tests can reproduce slow or flaky EC2
+// behavior.
+type FakeVMClient struct {
+	mu        sync.Mutex
+	instances map[string]*Instance
+	nextID    int
+
+	// Delay is applied before every operation, simulating API latency.
+	Delay time.Duration
+	// MaxInstances caps the number of non-terminated instances the fake
+	// will allow, simulating a quota limit. Zero means unlimited.
+	MaxInstances int
+	// FailRunInstance, when set, is returned by RunInstance instead of
+	// creating an instance.
+	FailRunInstance error
+	// FailTerminateInstances, when set, is returned by TerminateInstances
+	// instead of terminating instances.
+	FailTerminateInstances error
+}
+
+// NewFakeVMClient returns an empty FakeVMClient ready for use.
+func NewFakeVMClient() *FakeVMClient {
+	return &FakeVMClient{instances: make(map[string]*Instance)}
+}
+
+func (c *FakeVMClient) sleep() {
+	if c.Delay > 0 {
+		time.Sleep(c.Delay)
+	}
+}
+
+func (c *FakeVMClient) RunInstance(ctx context.Context, input RunInstanceInput) (*Instance, error) {
+	c.sleep()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.FailRunInstance != nil {
+		return nil, c.FailRunInstance
+	}
+	if c.MaxInstances > 0 && c.countRunning() >= c.MaxInstances {
+		return nil, fmt.Errorf("quota exceeded: %d instances already running", c.MaxInstances)
+	}
+
+	c.nextID++
+	id := fmt.Sprintf("i-fake%06d", c.nextID)
+
+	tags := make(map[string]string, len(input.Tags)+1)
+	for k, v := range input.Tags {
+		tags[k] = v
+	}
+	tags["Name"] = input.Name
+
+	instance := &Instance{
+		ID:               id,
+		State:            "pending",
+		InstanceType:     input.InstanceType,
+		ImageID:          input.AMI,
+		KeyName:          input.KeyName,
+		SubnetID:         input.SubnetID,
+		SecurityGroupIDs: append([]string(nil), input.VPCSecurityGroupIDs...),
+		Tags:             tags,
+		LaunchTime:       time.Now(),
+	}
+	c.instances[id] = instance
+
+	copy := *instance
+	return &copy, nil
+}
+
+func (c *FakeVMClient) DescribeInstancesByTag(ctx context.Context, name string) ([]Instance, error) {
+	c.sleep()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result []Instance
+	for _, instance := range c.instances {
+		if instance.State == "terminated" {
+			continue
+		}
+		if instance.Tags["Name"] == name {
+			result = append(result, *instance)
+		}
+	}
+	return result, nil
+}
+
+func (c *FakeVMClient) TerminateInstances(ctx context.Context, instanceIDs []string) error {
+	c.sleep()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.FailTerminateInstances != nil {
+		return c.FailTerminateInstances
+	}
+
+	for _, id := range instanceIDs {
+		if instance, ok := c.instances[id]; ok {
+			instance.State = "terminated"
+		}
+	}
+	return nil
+}
+
+func (c *FakeVMClient) WaitUntilRunning(ctx context.Context, instanceID string) error {
+	c.sleep()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	instance, ok := c.instances[instanceID]
+	if !ok {
+		return fmt.Errorf("instance %s not found", instanceID)
+	}
+	instance.State = "running"
+	return nil
+}
+
+func (c *FakeVMClient) countRunning() int {
+	count := 0
+	for _, instance := range c.instances {
+		if instance.State != "terminated" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,101 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFakeVMClientRunInstanceAndDescribe(t *testing.T) {
+	client := NewFakeVMClient()
+	ctx := context.Background()
+
+	instance, err := client.RunInstance(ctx, RunInstanceInput{
+		Name:         "web",
+		InstanceType: "t3.micro",
+		AMI:          "ami-123",
+		SubnetID:     "subnet-1",
+	})
+	if err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+	if instance.State != "pending" {
+		t.Errorf("State = %q, want %q", instance.State, "pending")
+	}
+
+	found, err := client.DescribeInstancesByTag(ctx, "web")
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != instance.ID {
+		t.Fatalf("DescribeInstancesByTag = %+v, want one instance with ID %s", found, instance.ID)
+	}
+
+	other, err := client.DescribeInstancesByTag(ctx, "other")
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(other) != 0 {
+		t.Errorf("DescribeInstancesByTag(\"other\") = %+v, want none", other)
+	}
+}
+
+func TestFakeVMClientTerminateExcludesFromDescribe(t *testing.T) {
+	client := NewFakeVMClient()
+	ctx := context.Background()
+
+	instance, err := client.RunInstance(ctx, RunInstanceInput{Name: "web", AMI: "ami-123", SubnetID: "subnet-1"})
+	if err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+
+	if err := client.TerminateInstances(ctx, []string{instance.ID}); err != nil {
+		t.Fatalf("TerminateInstances: %v", err)
+	}
+
+	found, err := client.DescribeInstancesByTag(ctx, "web")
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("DescribeInstancesByTag after terminate = %+v, want none", found)
+	}
+}
+
+func TestFakeVMClientMaxInstancesQuota(t *testing.T) {
+	client := NewFakeVMClient()
+	client.MaxInstances = 1
+	ctx := context.Background()
+
+	if _, err := client.RunInstance(ctx, RunInstanceInput{Name: "web", AMI: "ami-123", SubnetID: "subnet-1"}); err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+	if _, err := client.RunInstance(ctx, RunInstanceInput{Name: "web", AMI: "ami-123", SubnetID: "subnet-1"}); err == nil {
+		t.Error("RunInstance past MaxInstances: want error, got nil")
+	}
+}
+
+func TestFakeVMClientWaitUntilRunning(t *testing.T) {
+	client := NewFakeVMClient()
+	ctx := context.Background()
+
+	instance, err := client.RunInstance(ctx, RunInstanceInput{Name: "web", AMI: "ami-123", SubnetID: "subnet-1"})
+	if err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+
+	if err := client.WaitUntilRunning(ctx, instance.ID); err != nil {
+		t.Fatalf("WaitUntilRunning: %v", err)
+	}
+
+	found, err := client.DescribeInstancesByTag(ctx, "web")
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(found) != 1 || found[0].State != "running" {
+		t.Fatalf("DescribeInstancesByTag = %+v, want one running instance", found)
+	}
+
+	if err := client.WaitUntilRunning(ctx, "i-does-not-exist"); err == nil {
+		t.Error("WaitUntilRunning on unknown instance: want error, got nil")
+	}
+}
@@ -0,0 +1,103 @@
+package cloud
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyPairAPI abstracts the EC2 key-pair operations KeyManager needs, so the
+// generate/reuse/rotate decision can be exercised in tests without talking
+// to real EC2. *ec2.Client satisfies it.
+type keyPairAPI interface {
+	DeleteKeyPair(ctx context.Context, params *ec2.DeleteKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error)
+	ImportKeyPair(ctx context.Context, params *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error)
+	DescribeKeyPairs(ctx context.Context, params *ec2.DescribeKeyPairsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error)
+}
+
+// KeyManager generates and registers EC2 key pairs on demand, so operators no
+// longer need to pre-create one out of band. Generated private keys are
+// cached on disk under KeyDir and reused across runs unless Rotate is set.
+type KeyManager struct {
+	ec2    keyPairAPI
+	KeyDir string
+	Rotate bool
+}
+
+// NewKeyManager builds a KeyManager from an ec2.Client already scoped to the
+// target region.
+func NewKeyManager(client keyPairAPI, keyDir string, rotate bool) *KeyManager {
+	return &KeyManager{ec2: client, KeyDir: keyDir, Rotate: rotate}
+}
+
+// EnsureKeyPair returns name, registered in EC2 and backed by a private key
+// file at KeyDir/name.pem, generating and importing a fresh 2048-bit RSA key
+// pair if one doesn't already exist, or if m.Rotate is set. The second
+// return value reports whether a new key pair was generated, since instances
+// launched under the previous one need to be replaced for the new key to
+// take effect.
+func (m *KeyManager) EnsureKeyPair(ctx context.Context, name string) (string, bool, error) {
+	keyPath := filepath.Join(m.KeyDir, name+".pem")
+
+	if !m.Rotate && m.keyPairReady(ctx, name, keyPath) {
+		return name, false, nil
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to generate RSA key: %v", err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to derive SSH public key: %v", err)
+	}
+
+	// A previous pair under this name may already be registered, either
+	// because m.Rotate asked for a fresh one or because the local .pem was
+	// lost (e.g. the instance running this process was replaced) while EC2
+	// still has it; either way ImportKeyPair below needs the name to be
+	// free, and deleting a name that isn't registered is a no-op.
+	_, _ = m.ec2.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{KeyName: aws.String(name)})
+
+	if _, err := m.ec2.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(name),
+		PublicKeyMaterial: ssh.MarshalAuthorizedKey(publicKey),
+	}); err != nil {
+		return "", false, fmt.Errorf("failed to import key pair %s: %v", name, err)
+	}
+
+	if err := os.MkdirAll(m.KeyDir, 0o755); err != nil {
+		return "", false, fmt.Errorf("failed to create key directory: %v", err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	if err := os.WriteFile(keyPath, pemBytes, 0o600); err != nil {
+		return "", false, fmt.Errorf("failed to write private key file: %v", err)
+	}
+
+	return name, true, nil
+}
+
+// keyPairReady reports whether name is both registered in EC2 and backed by
+// a private key file on disk, so a partially-completed previous run (e.g. an
+// import that succeeded but a crash before the file was written) is treated
+// as not ready and regenerated.
+func (m *KeyManager) keyPairReady(ctx context.Context, name, keyPath string) bool {
+	if _, err := os.Stat(keyPath); err != nil {
+		return false
+	}
+	_, err := m.ec2.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{KeyNames: []string{name}})
+	return err == nil
+}
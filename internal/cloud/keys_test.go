@@ -0,0 +1,156 @@
+package cloud
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// fakeKeyPairAPI is an in-memory keyPairAPI that tracks how many times each
+// operation was called, so tests can assert whether EnsureKeyPair actually
+// talked to EC2 or reused what was already there.
+type fakeKeyPairAPI struct {
+	registered        map[string]bool
+	importCalls       int
+	deleteCalls       int
+	describeCalls     int
+	failDescribeUnset bool // if true, DescribeKeyPairs errors for names not in registered
+}
+
+func newFakeKeyPairAPI() *fakeKeyPairAPI {
+	return &fakeKeyPairAPI{registered: map[string]bool{}}
+}
+
+func (f *fakeKeyPairAPI) DeleteKeyPair(ctx context.Context, params *ec2.DeleteKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error) {
+	f.deleteCalls++
+	delete(f.registered, aws.ToString(params.KeyName))
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+func (f *fakeKeyPairAPI) ImportKeyPair(ctx context.Context, params *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error) {
+	f.importCalls++
+	f.registered[aws.ToString(params.KeyName)] = true
+	return &ec2.ImportKeyPairOutput{}, nil
+}
+
+func (f *fakeKeyPairAPI) DescribeKeyPairs(ctx context.Context, params *ec2.DescribeKeyPairsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error) {
+	f.describeCalls++
+	for _, name := range params.KeyNames {
+		if !f.registered[name] {
+			return nil, &ec2Error{"key pair not found"}
+		}
+	}
+	return &ec2.DescribeKeyPairsOutput{}, nil
+}
+
+type ec2Error struct{ msg string }
+
+func (e *ec2Error) Error() string { return e.msg }
+
+func TestEnsureKeyPairGeneratesOnFirstCall(t *testing.T) {
+	api := newFakeKeyPairAPI()
+	manager := NewKeyManager(api, t.TempDir(), false)
+	ctx := context.Background()
+
+	name, rotated, err := manager.EnsureKeyPair(ctx, "on-cloud-test")
+	if err != nil {
+		t.Fatalf("EnsureKeyPair: %v", err)
+	}
+	if name != "on-cloud-test" {
+		t.Errorf("name = %q, want %q", name, "on-cloud-test")
+	}
+	if !rotated {
+		t.Error("rotated = false on first call, want true")
+	}
+	if api.importCalls != 1 {
+		t.Errorf("importCalls = %d, want 1", api.importCalls)
+	}
+}
+
+func TestEnsureKeyPairReusesExistingWithoutRotate(t *testing.T) {
+	api := newFakeKeyPairAPI()
+	keyDir := t.TempDir()
+	manager := NewKeyManager(api, keyDir, false)
+	ctx := context.Background()
+
+	if _, _, err := manager.EnsureKeyPair(ctx, "on-cloud-test"); err != nil {
+		t.Fatalf("EnsureKeyPair (first call): %v", err)
+	}
+
+	_, rotated, err := manager.EnsureKeyPair(ctx, "on-cloud-test")
+	if err != nil {
+		t.Fatalf("EnsureKeyPair (second call): %v", err)
+	}
+	if rotated {
+		t.Error("rotated = true on second call without Rotate, want false")
+	}
+	if api.importCalls != 1 {
+		t.Errorf("importCalls = %d after second call, want still 1 (reused)", api.importCalls)
+	}
+}
+
+func TestEnsureKeyPairRegeneratesWhenFileMissing(t *testing.T) {
+	api := newFakeKeyPairAPI()
+	manager := NewKeyManager(api, t.TempDir(), false)
+	ctx := context.Background()
+
+	if _, _, err := manager.EnsureKeyPair(ctx, "on-cloud-test"); err != nil {
+		t.Fatalf("EnsureKeyPair (first call): %v", err)
+	}
+
+	// Simulate the process running this code having been replaced: EC2
+	// still has the key pair registered, but its .pem is gone locally.
+	manager.KeyDir = t.TempDir()
+
+	_, rotated, err := manager.EnsureKeyPair(ctx, "on-cloud-test")
+	if err != nil {
+		t.Fatalf("EnsureKeyPair (after file loss): %v", err)
+	}
+	if !rotated {
+		t.Error("rotated = false with missing local .pem, want true (treated as not ready)")
+	}
+	if api.importCalls != 2 {
+		t.Errorf("importCalls = %d, want 2 (regenerated after file loss)", api.importCalls)
+	}
+}
+
+func TestEnsureKeyPairAlwaysRegeneratesWhenRotateSet(t *testing.T) {
+	api := newFakeKeyPairAPI()
+	manager := NewKeyManager(api, t.TempDir(), true)
+	ctx := context.Background()
+
+	if _, rotated, err := manager.EnsureKeyPair(ctx, "on-cloud-test"); err != nil || !rotated {
+		t.Fatalf("EnsureKeyPair (first call): rotated=%v err=%v", rotated, err)
+	}
+	if _, rotated, err := manager.EnsureKeyPair(ctx, "on-cloud-test"); err != nil || !rotated {
+		t.Fatalf("EnsureKeyPair (second call): rotated=%v err=%v", rotated, err)
+	}
+	if api.importCalls != 2 {
+		t.Errorf("importCalls = %d, want 2 (Rotate regenerates on every call)", api.importCalls)
+	}
+}
+
+func TestEnsureKeyPairWritesPrivateKeyFile(t *testing.T) {
+	api := newFakeKeyPairAPI()
+	keyDir := t.TempDir()
+	manager := NewKeyManager(api, keyDir, false)
+	ctx := context.Background()
+
+	name, _, err := manager.EnsureKeyPair(ctx, "on-cloud-test")
+	if err != nil {
+		t.Fatalf("EnsureKeyPair: %v", err)
+	}
+
+	keyPath := filepath.Join(keyDir, name+".pem")
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("expected private key file at %s: %v", keyPath, err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("private key file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,150 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+)
+
+// runningOnDemandStandardQuotaCode is "Running On-Demand Standard (A, C, D,
+// H, I, M, R, T, Z) instances" under the ec2 service.
+const runningOnDemandStandardQuotaCode = "L-1216C47A"
+
+// quotaAPI abstracts the AWS Service Quotas operation QuotaChecker needs, so
+// its vCPU-accounting math can be exercised in tests without talking to AWS.
+type quotaAPI interface {
+	GetServiceQuota(ctx context.Context, params *servicequotas.GetServiceQuotaInput, optFns ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error)
+}
+
+// instanceTypeAPI abstracts the EC2 operation QuotaChecker needs to look up
+// vCPU counts per instance type.
+type instanceTypeAPI interface {
+	DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+}
+
+// CapacityChecker is satisfied by *QuotaChecker. Callers that only need to
+// preflight capacity (rather than construct a checker) can depend on this
+// instead, so a fake can stand in for tests.
+type CapacityChecker interface {
+	CheckCapacity(ctx context.Context, region, instanceType string, instancesToCreate int, existing []Instance) error
+}
+
+// QuotaChecker preflights RunInstances calls against the account's EC2
+// vCPU service quota, so quota exhaustion fails fast with a clear message
+// instead of surfacing as an opaque VcpuLimitExceeded error mid-retry-loop.
+type QuotaChecker struct {
+	quotas quotaAPI
+	ec2    instanceTypeAPI
+
+	mu         sync.Mutex
+	vcpuCache  map[string]int32   // region+"/"+instance type -> default vCPUs
+	quotaCache map[string]float64 // region -> vCPU quota value
+}
+
+// NewQuotaChecker builds a QuotaChecker from an AWS config already scoped to
+// the target region.
+func NewQuotaChecker(awsCfg aws.Config) *QuotaChecker {
+	return &QuotaChecker{
+		quotas:     servicequotas.NewFromConfig(awsCfg),
+		ec2:        ec2.NewFromConfig(awsCfg),
+		vcpuCache:  map[string]int32{},
+		quotaCache: map[string]float64{},
+	}
+}
+
+// CheckCapacity fails if launching instancesToCreate more instanceType
+// instances, on top of the vCPUs already used by existing, would exceed the
+// region's running On-Demand Standard instances quota.
+func (q *QuotaChecker) CheckCapacity(ctx context.Context, region, instanceType string, instancesToCreate int, existing []Instance) error {
+	if instancesToCreate <= 0 {
+		return nil
+	}
+
+	vcpusPerInstance, err := q.vcpusForType(ctx, region, instanceType)
+	if err != nil {
+		return fmt.Errorf("failed to determine vCPUs for %s: %v", instanceType, err)
+	}
+
+	quota, err := q.quotaForRegion(ctx, region)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s quota: %v", runningOnDemandStandardQuotaCode, err)
+	}
+
+	var runningVCPUs int32
+	for _, instance := range existing {
+		vcpus, err := q.vcpusForType(ctx, region, instance.InstanceType)
+		if err != nil {
+			return fmt.Errorf("failed to determine vCPUs for %s: %v", instance.InstanceType, err)
+		}
+		runningVCPUs += vcpus
+	}
+
+	projected := runningVCPUs + int32(instancesToCreate)*vcpusPerInstance
+	if float64(projected) > quota {
+		return fmt.Errorf(
+			"creating %d x %s instance(s) would raise running vCPUs from %d to %d, exceeding the %s quota of %.0f vCPUs in %s",
+			instancesToCreate, instanceType, runningVCPUs, projected, runningOnDemandStandardQuotaCode, quota, region,
+		)
+	}
+	return nil
+}
+
+func (q *QuotaChecker) vcpusForType(ctx context.Context, region, instanceType string) (int32, error) {
+	key := region + "/" + instanceType
+
+	q.mu.Lock()
+	if vcpus, ok := q.vcpuCache[key]; ok {
+		q.mu.Unlock()
+		return vcpus, nil
+	}
+	q.mu.Unlock()
+
+	result, err := q.ec2.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []ec2types.InstanceType{ec2types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(result.InstanceTypes) == 0 || result.InstanceTypes[0].VCpuInfo == nil {
+		return 0, fmt.Errorf("no vCPU info returned for instance type %s", instanceType)
+	}
+	vcpus := aws.ToInt32(result.InstanceTypes[0].VCpuInfo.DefaultVCpus)
+
+	q.mu.Lock()
+	q.vcpuCache[key] = vcpus
+	q.mu.Unlock()
+
+	return vcpus, nil
+}
+
+func (q *QuotaChecker) quotaForRegion(ctx context.Context, region string) (float64, error) {
+	q.mu.Lock()
+	if quota, ok := q.quotaCache[region]; ok {
+		q.mu.Unlock()
+		return quota, nil
+	}
+	q.mu.Unlock()
+
+	result, err := q.quotas.GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String("ec2"),
+		QuotaCode:   aws.String(runningOnDemandStandardQuotaCode),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if result.Quota == nil || result.Quota.Value == nil {
+		return 0, fmt.Errorf("service quotas returned no value for %s", runningOnDemandStandardQuotaCode)
+	}
+	quota := *result.Quota.Value
+
+	q.mu.Lock()
+	q.quotaCache[region] = quota
+	q.mu.Unlock()
+
+	return quota, nil
+}
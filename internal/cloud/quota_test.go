@@ -0,0 +1,129 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	sqtypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+)
+
+// fakeQuotaAPI is an in-memory quotaAPI returning a fixed quota value and
+// counting calls, so tests can assert QuotaChecker caches it.
+type fakeQuotaAPI struct {
+	value float64
+	calls int
+}
+
+func (f *fakeQuotaAPI) GetServiceQuota(ctx context.Context, params *servicequotas.GetServiceQuotaInput, optFns ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error) {
+	f.calls++
+	return &servicequotas.GetServiceQuotaOutput{
+		Quota: &sqtypes.ServiceQuota{Value: aws.Float64(f.value)},
+	}, nil
+}
+
+// fakeInstanceTypeAPI is an in-memory instanceTypeAPI mapping instance types
+// to a fixed default vCPU count, and counting calls per type so tests can
+// assert QuotaChecker caches the lookup.
+type fakeInstanceTypeAPI struct {
+	vcpus map[string]int32
+	calls map[string]int
+}
+
+func newFakeInstanceTypeAPI(vcpus map[string]int32) *fakeInstanceTypeAPI {
+	return &fakeInstanceTypeAPI{vcpus: vcpus, calls: map[string]int{}}
+}
+
+func (f *fakeInstanceTypeAPI) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	instanceType := string(params.InstanceTypes[0])
+	f.calls[instanceType]++
+	vcpus, ok := f.vcpus[instanceType]
+	if !ok {
+		return &ec2.DescribeInstanceTypesOutput{}, nil
+	}
+	return &ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []ec2types.InstanceTypeInfo{
+			{VCpuInfo: &ec2types.VCpuInfo{DefaultVCpus: aws.Int32(vcpus)}},
+		},
+	}, nil
+}
+
+func newTestQuotaChecker(quota float64, vcpus map[string]int32) (*QuotaChecker, *fakeQuotaAPI, *fakeInstanceTypeAPI) {
+	quotaAPI := &fakeQuotaAPI{value: quota}
+	instanceTypeAPI := newFakeInstanceTypeAPI(vcpus)
+	return &QuotaChecker{
+		quotas:     quotaAPI,
+		ec2:        instanceTypeAPI,
+		vcpuCache:  map[string]int32{},
+		quotaCache: map[string]float64{},
+	}, quotaAPI, instanceTypeAPI
+}
+
+func TestCheckCapacityAllowsWithinQuota(t *testing.T) {
+	checker, _, _ := newTestQuotaChecker(8, map[string]int32{"t3.micro": 2})
+	existing := []Instance{{InstanceType: "t3.micro"}, {InstanceType: "t3.micro"}}
+
+	if err := checker.CheckCapacity(context.Background(), "us-east-1", "t3.micro", 2, existing); err != nil {
+		t.Fatalf("CheckCapacity = %v, want nil (2 existing + 2 new = 8 vCPUs, at quota)", err)
+	}
+}
+
+func TestCheckCapacityRejectsOverQuota(t *testing.T) {
+	checker, _, _ := newTestQuotaChecker(8, map[string]int32{"t3.micro": 2})
+	existing := []Instance{{InstanceType: "t3.micro"}, {InstanceType: "t3.micro"}}
+
+	err := checker.CheckCapacity(context.Background(), "us-east-1", "t3.micro", 3, existing)
+	if err == nil {
+		t.Fatal("CheckCapacity = nil, want error (2 existing + 3 new = 10 vCPUs, over an 8 vCPU quota)")
+	}
+}
+
+func TestCheckCapacityAccountsForMixedInstanceTypes(t *testing.T) {
+	checker, _, _ := newTestQuotaChecker(16, map[string]int32{"t3.micro": 2, "m5.large": 4})
+	existing := []Instance{{InstanceType: "t3.micro"}, {InstanceType: "m5.large"}} // 2 + 4 = 6
+
+	// 6 running + 2 new m5.large (8) = 14, within 16.
+	if err := checker.CheckCapacity(context.Background(), "us-east-1", "m5.large", 2, existing); err != nil {
+		t.Fatalf("CheckCapacity = %v, want nil", err)
+	}
+
+	// 6 running + 3 new m5.large (12) = 18, over 16.
+	if err := checker.CheckCapacity(context.Background(), "us-east-1", "m5.large", 3, existing); err == nil {
+		t.Fatal("CheckCapacity = nil, want error (18 vCPUs over a 16 vCPU quota)")
+	}
+}
+
+func TestCheckCapacitySkipsPreflightWhenNotCreating(t *testing.T) {
+	checker, quotaAPI, instanceTypeAPI := newTestQuotaChecker(1, map[string]int32{"t3.micro": 2})
+	existing := []Instance{{InstanceType: "t3.micro"}, {InstanceType: "t3.micro"}}
+
+	// 2 existing instances already exceed the tiny quota of 1, but
+	// instancesToCreate <= 0 means nothing new is being launched.
+	if err := checker.CheckCapacity(context.Background(), "us-east-1", "t3.micro", 0, existing); err != nil {
+		t.Fatalf("CheckCapacity with instancesToCreate=0 = %v, want nil", err)
+	}
+	if quotaAPI.calls != 0 || instanceTypeAPI.calls["t3.micro"] != 0 {
+		t.Error("CheckCapacity with instancesToCreate=0 should not query quota or vCPUs at all")
+	}
+}
+
+func TestCheckCapacityCachesQuotaAndVCPUsPerProcess(t *testing.T) {
+	checker, quotaAPI, instanceTypeAPI := newTestQuotaChecker(100, map[string]int32{"t3.micro": 2})
+	existing := []Instance{{InstanceType: "t3.micro"}}
+
+	for i := 0; i < 3; i++ {
+		if err := checker.CheckCapacity(context.Background(), "us-east-1", "t3.micro", 1, existing); err != nil {
+			t.Fatalf("CheckCapacity call %d: %v", i, err)
+		}
+	}
+
+	if quotaAPI.calls != 1 {
+		t.Errorf("GetServiceQuota calls = %d, want 1 (cached across calls)", quotaAPI.calls)
+	}
+	if instanceTypeAPI.calls["t3.micro"] != 1 {
+		t.Errorf("DescribeInstanceTypes calls = %d, want 1 (cached across calls)", instanceTypeAPI.calls["t3.micro"])
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Kedesai/on-cloud/internal/cloud"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+)
+
+// mtdStateDir holds one rotation state file per region, so concurrent
+// per-region MTD loops never share a single file and clobber each other's
+// writes (each loop calls state.save() independently, with no cross-region
+// locking or merging).
+const mtdStateDir = ".on-cloud/mtd-state"
+
+// mtdStatePath returns the rotation state file for region.
+func mtdStatePath(region string) string {
+	return filepath.Join(mtdStateDir, region+".yaml")
+}
+
+// MTDConfig configures the moving-target-defense reconciliation loop, which
+// continuously rotates instance identities rather than converging once and
+// exiting.
+type MTDConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Interval string `yaml:"interval"` // parsed with time.ParseDuration, e.g. "30m"
+}
+
+// interval parses Interval, defaulting to 30 minutes if unset.
+func (m MTDConfig) interval() (time.Duration, error) {
+	if m.Interval == "" {
+		return 30 * time.Minute, nil
+	}
+	return time.ParseDuration(m.Interval)
+}
+
+// RotationState tracks in-flight rotation progress for a single service
+// (identified by its EC2 Name tag) so a restart doesn't double-rotate.
+type RotationState struct {
+	LastRotation       time.Time `yaml:"last_rotation"`
+	PendingReplacement string    `yaml:"pending_replacement,omitempty"`
+}
+
+// MTDState is the on-disk rotation state for every service this process
+// reconciles.
+type MTDState struct {
+	Services map[string]RotationState `yaml:"services"`
+}
+
+func loadMTDState(path string) (*MTDState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &MTDState{Services: map[string]RotationState{}}, nil
+		}
+		return nil, fmt.Errorf("failed to read MTD state file: %v", err)
+	}
+
+	var state MTDState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse MTD state file: %v", err)
+	}
+	if state.Services == nil {
+		state.Services = map[string]RotationState{}
+	}
+	return &state, nil
+}
+
+func (s *MTDState) save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal MTD state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create MTD state directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write MTD state file: %v", err)
+	}
+	return nil
+}
+
+// rotationEvent is emitted to stdout as a single line of JSON per rotation
+// so the reconciliation loop is observable.
+type rotationEvent struct {
+	Service       string    `json:"service"`
+	Action        string    `json:"action"`
+	OldInstanceID string    `json:"old_instance_id,omitempty"`
+	NewInstanceID string    `json:"new_instance_id,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+func emitRotationEvent(e rotationEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("failed to marshal rotation event: %v", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// runMTDForAllRegions starts one MTD rotation loop per resolved region and
+// blocks until all of them return (in practice, forever, since each loop
+// only returns on a fatal per-region setup error).
+func runMTDForAllRegions(ctx context.Context, cfg *MainConfig, amiResolver *cloud.AMIResolver, rotateKeys bool) {
+	regionConfigs, err := resolveRegionConfigs(cfg)
+	if err != nil {
+		log.Fatalf("Failed to resolve region configuration for MTD: %v", err)
+	}
+
+	var g errgroup.Group
+	for _, rc := range regionConfigs {
+		rc := rc
+		g.Go(func() error {
+			if err := runMTDLoopForRegion(ctx, rc, cfg.MTD, amiResolver, rotateKeys); err != nil {
+				log.Printf("MTD reconciliation loop failed for %s: %v", rc.Region, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// runMTDLoopForRegion builds an AWS client for rc.Region and rotates its
+// instances on mtdCfg.Interval until ctx is cancelled. On startup it
+// finishes any rotation that was interrupted mid-swap before entering the
+// steady-state loop.
+func runMTDLoopForRegion(ctx context.Context, rc regionConfig, mtdCfg MTDConfig, amiResolver *cloud.AMIResolver, rotateKeys bool) error {
+	interval, err := mtdCfg.interval()
+	if err != nil {
+		return fmt.Errorf("invalid mtd.interval: %v", err)
+	}
+
+	rc, err = resolveAMI(ctx, rc, amiResolver)
+	if err != nil {
+		return err
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(rc.Region))
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	keyManager := cloud.NewKeyManager(ec2.NewFromConfig(awsCfg), keyDir, rotateKeys)
+	rc, _, err = ensureKeyPair(ctx, rc, keyManager, rotateKeys)
+	if err != nil {
+		return err
+	}
+
+	client := cloud.NewAWSVMClient(ec2.NewFromConfig(awsCfg))
+	quota := cloud.NewQuotaChecker(awsCfg)
+
+	return runMTDLoop(ctx, client, quota, rc, interval, mtdStatePath(rc.Region))
+}
+
+// runMTDLoop rotates instances for rc's service on the given interval until
+// ctx is cancelled. It is separated from runMTDLoopForRegion so the
+// rotation logic can be exercised against a cloud.FakeVMClient.
+func runMTDLoop(ctx context.Context, client cloud.VMClient, quota cloud.CapacityChecker, rc regionConfig, interval time.Duration, statePath string) error {
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		return err
+	}
+
+	serviceKey := mtdServiceKey(rc)
+	if err := resumeStrandedRotation(ctx, client, rc, state, statePath); err != nil {
+		return fmt.Errorf("failed to resume stranded rotation for %s: %v", serviceKey, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := rotateInstance(ctx, client, quota, rc, state, statePath); err != nil {
+				log.Printf("MTD rotation failed for %s: %v", serviceKey, err)
+			}
+		}
+	}
+}
+
+// mtdServiceKey identifies a service's rotation state, scoped by region so
+// the same service name in two regions doesn't share state.
+func mtdServiceKey(rc regionConfig) string {
+	return rc.Region + "/" + rc.InstanceCfg.Name
+}
+
+// resumeStrandedRotation detects the case where a previous run recorded a
+// replacement instance but crashed before terminating the old one, leaving
+// the fleet one instance over DesiredCount. It finishes the swap.
+func resumeStrandedRotation(ctx context.Context, client cloud.VMClient, rc regionConfig, state *MTDState, statePath string) error {
+	serviceKey := mtdServiceKey(rc)
+	rotation := state.Services[serviceKey]
+	if rotation.PendingReplacement == "" {
+		return nil
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to describe instances: %v", err)
+	}
+	if len(existing) <= rc.InstanceCfg.DesiredCount {
+		// The old instance is already gone; nothing left to strand.
+		rotation.PendingReplacement = ""
+		state.Services[serviceKey] = rotation
+		return state.save(statePath)
+	}
+
+	oldest := oldestInstanceExcluding(existing, rotation.PendingReplacement)
+	if oldest == nil {
+		return nil
+	}
+	if err := client.TerminateInstances(ctx, []string{oldest.ID}); err != nil {
+		return fmt.Errorf("failed to terminate stranded instance %s: %v", oldest.ID, err)
+	}
+
+	emitRotationEvent(rotationEvent{
+		Service:       serviceKey,
+		Action:        "rotation_resumed",
+		OldInstanceID: oldest.ID,
+		NewInstanceID: rotation.PendingReplacement,
+		Timestamp:     time.Now(),
+	})
+
+	rotation.PendingReplacement = ""
+	rotation.LastRotation = time.Now()
+	state.Services[serviceKey] = rotation
+	return state.save(statePath)
+}
+
+// rotateInstance replaces the oldest running instance for rc's service with
+// a freshly launched one, keeping the fleet size at DesiredCount throughout.
+func rotateInstance(ctx context.Context, client cloud.VMClient, quota cloud.CapacityChecker, rc regionConfig, state *MTDState, statePath string) error {
+	serviceKey := mtdServiceKey(rc)
+	instanceConfig := rc.InstanceCfg
+
+	existing, err := client.DescribeInstancesByTag(ctx, instanceConfig.Name)
+	if err != nil {
+		return fmt.Errorf("failed to describe instances: %v", err)
+	}
+	if len(existing) != instanceConfig.DesiredCount {
+		// The MTD loop never calls back into the one-shot reconciler, so
+		// nothing else will converge fleet size back to DesiredCount;
+		// rotation would otherwise stop silently forever on any drift (an
+		// out-of-band termination, a stranded rotation resumeStrandedRotation
+		// didn't catch). Converge it here before resuming rotation.
+		log.Printf("MTD rotation for %s skipped this tick: found %d instance(s), want %d; reconciling fleet size first", serviceKey, len(existing), instanceConfig.DesiredCount)
+		return reconcileFleetSize(ctx, client, quota, rc, existing)
+	}
+
+	oldest := oldestInstanceExcluding(existing, "")
+	if oldest == nil {
+		return nil
+	}
+
+	// A rotation always transiently needs headroom for one extra instance,
+	// since the replacement is launched before the old instance is
+	// terminated; preflight it the same way the one-shot reconciler does so
+	// quota exhaustion fails with a clear message instead of an opaque
+	// VcpuLimitExceeded mid-rotation.
+	if err := quota.CheckCapacity(ctx, rc.Region, instanceConfig.InstanceType, 1, existing); err != nil {
+		return fmt.Errorf("service-quota preflight failed: %v", err)
+	}
+
+	replacement, err := client.RunInstance(ctx, cloud.RunInstanceInput{
+		Name:                instanceConfig.Name,
+		InstanceType:        instanceConfig.InstanceType,
+		AMI:                 instanceConfig.AMI,
+		KeyName:             instanceConfig.KeyName,
+		SubnetID:            rc.SubnetID,
+		VPCSecurityGroupIDs: instanceConfig.VPCSecurityGroupIDs,
+		Monitoring:          instanceConfig.Monitoring,
+		Tags:                instanceConfig.Tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to launch replacement instance: %v", err)
+	}
+
+	// Record the replacement before terminating the old instance so a crash
+	// here is resumed by resumeStrandedRotation on the next startup.
+	rotation := state.Services[serviceKey]
+	rotation.PendingReplacement = replacement.ID
+	state.Services[serviceKey] = rotation
+	if err := state.save(statePath); err != nil {
+		return err
+	}
+
+	if err := client.WaitUntilRunning(ctx, replacement.ID); err != nil {
+		return fmt.Errorf("replacement instance %s never became running: %v", replacement.ID, err)
+	}
+
+	if err := client.TerminateInstances(ctx, []string{oldest.ID}); err != nil {
+		return fmt.Errorf("failed to terminate rotated instance %s: %v", oldest.ID, err)
+	}
+
+	emitRotationEvent(rotationEvent{
+		Service:       serviceKey,
+		Action:        "rotation_completed",
+		OldInstanceID: oldest.ID,
+		NewInstanceID: replacement.ID,
+		Timestamp:     time.Now(),
+	})
+
+	rotation.PendingReplacement = ""
+	rotation.LastRotation = time.Now()
+	state.Services[serviceKey] = rotation
+	return state.save(statePath)
+}
+
+// reconcileFleetSize brings the number of running instances for rc's service
+// back to DesiredCount, so rotateInstance's assumption that fleet size is
+// already at steady state holds again on the next tick.
+func reconcileFleetSize(ctx context.Context, client cloud.VMClient, quota cloud.CapacityChecker, rc regionConfig, existing []cloud.Instance) error {
+	diff := rc.InstanceCfg.DesiredCount - len(existing)
+	if diff > 0 {
+		if err := quota.CheckCapacity(ctx, rc.Region, rc.InstanceCfg.InstanceType, diff, existing); err != nil {
+			return fmt.Errorf("service-quota preflight failed: %v", err)
+		}
+		for i := 0; i < diff; i++ {
+			if err := createEC2InstanceWithRetry(ctx, client, rc.InstanceCfg, rc.SubnetID, rc.InstanceCfg.Name); err != nil {
+				return fmt.Errorf("failed to create EC2 instance: %v", err)
+			}
+		}
+		return nil
+	}
+	return terminateExcessInstances(ctx, client, existing, -diff)
+}
+
+// oldestInstanceExcluding returns the instance with the earliest LaunchTime,
+// skipping excludeID (typically an in-flight replacement).
+func oldestInstanceExcluding(instances []cloud.Instance, excludeID string) *cloud.Instance {
+	candidates := make([]cloud.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.ID == excludeID {
+			continue
+		}
+		candidates = append(candidates, instance)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LaunchTime.Before(candidates[j].LaunchTime)
+	})
+	return &candidates[0]
+}
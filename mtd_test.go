@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kedesai/on-cloud/internal/cloud"
+)
+
+// fakeCapacityChecker is an in-memory cloud.CapacityChecker for tests. It
+// records every call and either always allows or always rejects, so tests
+// can assert both that rotateInstance consults it and that it backs off
+// when quota is exhausted.
+type fakeCapacityChecker struct {
+	err   error
+	calls int
+}
+
+func (f *fakeCapacityChecker) CheckCapacity(ctx context.Context, region, instanceType string, instancesToCreate int, existing []cloud.Instance) error {
+	f.calls++
+	return f.err
+}
+
+func TestMTDStatePathIsPerRegion(t *testing.T) {
+	east := mtdStatePath("us-east-1")
+	west := mtdStatePath("eu-west-1")
+	if east == west {
+		t.Fatalf("mtdStatePath returned the same path for different regions: %q", east)
+	}
+	if filepath.Dir(east) != mtdStateDir || filepath.Dir(west) != mtdStateDir {
+		t.Fatalf("mtdStatePath(%q)=%q, mtdStatePath(%q)=%q, want both under %q", "us-east-1", east, "eu-west-1", west, mtdStateDir)
+	}
+}
+
+func testMTDRegionConfig() regionConfig {
+	return regionConfig{
+		Region:   "us-east-1",
+		SubnetID: "subnet-1",
+		InstanceCfg: EC2InstanceConfig{
+			Name:                "web",
+			InstanceType:        "t3.micro",
+			AMI:                 "ami-123",
+			KeyName:             "key-1",
+			VPCSecurityGroupIDs: []string{"sg-1"},
+			DesiredCount:        2,
+		},
+	}
+}
+
+func TestRotateInstanceReplacesOldestInstance(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	var oldest *cloud.Instance
+	for i := 0; i < rc.InstanceCfg.DesiredCount; i++ {
+		instance, err := client.RunInstance(ctx, cloud.RunInstanceInput{
+			Name:         rc.InstanceCfg.Name,
+			InstanceType: rc.InstanceCfg.InstanceType,
+			AMI:          rc.InstanceCfg.AMI,
+			SubnetID:     rc.SubnetID,
+		})
+		if err != nil {
+			t.Fatalf("RunInstance: %v", err)
+		}
+		if oldest == nil {
+			oldest = instance
+		}
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+
+	if err := rotateInstance(ctx, client, &fakeCapacityChecker{}, rc, state, statePath); err != nil {
+		t.Fatalf("rotateInstance: %v", err)
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("DescribeInstancesByTag = %d instances, want %d", len(existing), rc.InstanceCfg.DesiredCount)
+	}
+	for _, instance := range existing {
+		if instance.ID == oldest.ID {
+			t.Fatalf("oldest instance %s was not terminated by rotation", oldest.ID)
+		}
+	}
+
+	serviceKey := mtdServiceKey(rc)
+	if rotation := state.Services[serviceKey]; rotation.PendingReplacement != "" {
+		t.Fatalf("rotation.PendingReplacement = %q, want empty after a completed rotation", rotation.PendingReplacement)
+	}
+}
+
+func TestResumeStrandedRotationFinishesPendingSwap(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	for i := 0; i < rc.InstanceCfg.DesiredCount; i++ {
+		if _, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID}); err != nil {
+			t.Fatalf("RunInstance: %v", err)
+		}
+	}
+	// The replacement was already launched when the previous run crashed,
+	// leaving one instance over DesiredCount until the old one is terminated.
+	replacement, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID})
+	if err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+	serviceKey := mtdServiceKey(rc)
+	state.Services[serviceKey] = RotationState{PendingReplacement: replacement.ID}
+
+	if err := resumeStrandedRotation(ctx, client, rc, state, statePath); err != nil {
+		t.Fatalf("resumeStrandedRotation: %v", err)
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("DescribeInstancesByTag = %+v, want %d instances (back down to DesiredCount)", existing, rc.InstanceCfg.DesiredCount)
+	}
+	foundReplacement := false
+	for _, instance := range existing {
+		if instance.ID == replacement.ID {
+			foundReplacement = true
+		}
+	}
+	if !foundReplacement {
+		t.Fatalf("DescribeInstancesByTag = %+v, want replacement %s still present", existing, replacement.ID)
+	}
+
+	if rotation := state.Services[serviceKey]; rotation.PendingReplacement != "" {
+		t.Fatalf("rotation.PendingReplacement = %q, want empty after resuming", rotation.PendingReplacement)
+	}
+}
+
+func TestRotateInstanceReconcilesFleetSizeWhenBelowDesired(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	// Only one instance running, though DesiredCount is 2: e.g. a previous
+	// instance was terminated out-of-band.
+	if _, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID}); err != nil {
+		t.Fatalf("RunInstance: %v", err)
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+
+	if err := rotateInstance(ctx, client, &fakeCapacityChecker{}, rc, state, statePath); err != nil {
+		t.Fatalf("rotateInstance: %v", err)
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("DescribeInstancesByTag = %d instances, want %d (fleet size reconciled instead of rotation silently skipped)", len(existing), rc.InstanceCfg.DesiredCount)
+	}
+}
+
+func TestRotateInstanceReconcilesFleetSizeWhenAboveDesired(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	// One more instance running than DesiredCount, without a recorded
+	// pending rotation (so resumeStrandedRotation wouldn't catch it either).
+	for i := 0; i < rc.InstanceCfg.DesiredCount+1; i++ {
+		if _, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID}); err != nil {
+			t.Fatalf("RunInstance: %v", err)
+		}
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+
+	if err := rotateInstance(ctx, client, &fakeCapacityChecker{}, rc, state, statePath); err != nil {
+		t.Fatalf("rotateInstance: %v", err)
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("DescribeInstancesByTag = %d instances, want %d (fleet size reconciled instead of rotation silently skipped)", len(existing), rc.InstanceCfg.DesiredCount)
+	}
+}
+
+func TestRotateInstanceChecksQuotaBeforeLaunchingReplacement(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	for i := 0; i < rc.InstanceCfg.DesiredCount; i++ {
+		if _, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID}); err != nil {
+			t.Fatalf("RunInstance: %v", err)
+		}
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+
+	quota := &fakeCapacityChecker{}
+	if err := rotateInstance(ctx, client, quota, rc, state, statePath); err != nil {
+		t.Fatalf("rotateInstance: %v", err)
+	}
+	if quota.calls != 1 {
+		t.Errorf("quota.calls = %d, want 1 (rotation must preflight the replacement launch)", quota.calls)
+	}
+}
+
+func TestRotateInstanceFailsFastWhenQuotaExhausted(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testMTDRegionConfig()
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "mtd-state.yaml")
+
+	for i := 0; i < rc.InstanceCfg.DesiredCount; i++ {
+		if _, err := client.RunInstance(ctx, cloud.RunInstanceInput{Name: rc.InstanceCfg.Name, SubnetID: rc.SubnetID}); err != nil {
+			t.Fatalf("RunInstance: %v", err)
+		}
+	}
+
+	state, err := loadMTDState(statePath)
+	if err != nil {
+		t.Fatalf("loadMTDState: %v", err)
+	}
+
+	quota := &fakeCapacityChecker{err: fmt.Errorf("quota exhausted")}
+	if err := rotateInstance(ctx, client, quota, rc, state, statePath); err == nil {
+		t.Fatal("rotateInstance with exhausted quota: want error, got nil")
+	}
+
+	existing, err := client.DescribeInstancesByTag(ctx, rc.InstanceCfg.Name)
+	if err != nil {
+		t.Fatalf("DescribeInstancesByTag: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("DescribeInstancesByTag = %d instances, want still %d (no replacement launched past quota)", len(existing), rc.InstanceCfg.DesiredCount)
+	}
+}
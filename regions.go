@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/Kedesai/on-cloud/internal/cloud"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxConcurrentRegions bounds how many regions are reconciled at once, so a
+// fleet spanning many regions doesn't open an unbounded number of AWS
+// clients and API calls simultaneously.
+const maxConcurrentRegions = 5
+
+// keyDir is where generated EC2 key pairs' private keys are cached, mirroring
+// mtdStatePath's placement under .on-cloud/.
+const keyDir = ".on-cloud/keys"
+
+// regionConfig is the reconciliation target for one region: cfg's instance
+// config with that region's overrides (subnet, security groups, AMI)
+// applied. InstanceCfg.AMI may still be empty here if it's left to be
+// resolved from OSFamily/Architecture via AMIResolver.
+type regionConfig struct {
+	Region      string
+	SubnetID    string
+	InstanceCfg EC2InstanceConfig
+}
+
+// resolveRegionConfigs expands cfg.Region/cfg.Regions into one regionConfig
+// per region, applying cfg.RegionOverrides. AMI resolution from OSFamily
+// happens later, against each region's own AWS client.
+func resolveRegionConfigs(cfg *MainConfig) ([]regionConfig, error) {
+	regionNames := cfg.Regions
+	if len(regionNames) == 0 {
+		regionNames = []string{cfg.Region}
+	}
+
+	configs := make([]regionConfig, 0, len(regionNames))
+	for _, region := range regionNames {
+		instanceCfg := cfg.Resources.EC2Instance
+		subnetID := cfg.SubnetID
+
+		if override, ok := cfg.RegionOverrides[region]; ok {
+			if override.SubnetID != "" {
+				subnetID = override.SubnetID
+			}
+			if len(override.VPCSecurityGroupIDs) > 0 {
+				instanceCfg.VPCSecurityGroupIDs = override.VPCSecurityGroupIDs
+			}
+			if override.AMI != "" {
+				instanceCfg.AMI = override.AMI
+			}
+		}
+
+		if instanceCfg.AMI == "" && instanceCfg.OSFamily == "" {
+			return nil, fmt.Errorf("AMI or OSFamily is required for region %s", region)
+		}
+		if subnetID == "" {
+			return nil, fmt.Errorf("subnet ID is required for region %s", region)
+		}
+		if len(instanceCfg.VPCSecurityGroupIDs) == 0 {
+			return nil, fmt.Errorf("VPC security group IDs are required for region %s", region)
+		}
+
+		configs = append(configs, regionConfig{
+			Region:      region,
+			SubnetID:    subnetID,
+			InstanceCfg: instanceCfg,
+		})
+	}
+
+	return configs, nil
+}
+
+// resolveAMI fills in rc.InstanceCfg.AMI from OSFamily/Architecture via
+// resolver if it isn't already set explicitly (directly or via a region
+// override), which short-circuits resolution.
+func resolveAMI(ctx context.Context, rc regionConfig, resolver *cloud.AMIResolver) (regionConfig, error) {
+	if rc.InstanceCfg.AMI != "" {
+		return rc, nil
+	}
+	ami, err := resolver.Resolve(ctx, rc.Region, rc.InstanceCfg.OSFamily, rc.InstanceCfg.Architecture)
+	if err != nil {
+		return rc, fmt.Errorf("failed to resolve AMI: %v", err)
+	}
+	rc.InstanceCfg.AMI = ami
+	return rc, nil
+}
+
+// keyPairName derives a deterministic EC2 key pair name from the parts of
+// rc's config that identify a distinct launch configuration, so the same
+// service/region/instance-type/AMI combination always resolves to the same
+// generated key pair.
+func keyPairName(rc regionConfig) string {
+	sum := sha256.Sum256([]byte(rc.Region + "/" + rc.InstanceCfg.Name + "/" + rc.InstanceCfg.InstanceType + "/" + rc.InstanceCfg.AMI))
+	return "on-cloud-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// keyEnsurer is the subset of *cloud.KeyManager that ensureKeyPair needs, so
+// its memoization can be exercised in tests without constructing a real EC2
+// client.
+type keyEnsurer interface {
+	EnsureKeyPair(ctx context.Context, name string) (string, bool, error)
+}
+
+// keyPairCacheEntry is ensureKeyPair's memoized result for one key pair name.
+// Only successes are cached; a failed EnsureKeyPair call (e.g. a transient
+// EC2 API error) is retried by the next caller instead of being stuck
+// permanently, mirroring quota.go's vcpu/quota caches.
+type keyPairCacheEntry struct {
+	name string
+}
+
+// keyPairCache memoizes ensureKeyPair's result per key pair name for the
+// lifetime of the process. mainHandler's one-shot reconciliation and the MTD
+// loop each resolve their own region configs and call ensureKeyPair
+// independently; without this, a process with mtd.enabled and --rotate-keys
+// would delete and re-import a *different* key pair under the same
+// deterministic name on the MTD loop's first tick, leaving the on-disk .pem
+// mismatched with the instances already launched under the first key.
+var (
+	keyPairCacheMu sync.Mutex
+	keyPairCache   = map[string]keyPairCacheEntry{}
+)
+
+// ensureKeyPair fills in rc.InstanceCfg.KeyName with a generated-and-imported
+// key pair if one isn't already configured, or regenerates it if rotateKeys
+// is set. It reports whether a new key pair was generated, since instances
+// launched under the previous one need to be replaced for the new key to
+// take effect. Generation is memoized per key pair name, so every caller
+// resolving the same region/service within one process converges on a
+// single generated key instead of each regenerating it.
+func ensureKeyPair(ctx context.Context, rc regionConfig, manager keyEnsurer, rotateKeys bool) (regionConfig, bool, error) {
+	if rc.InstanceCfg.KeyName != "" && !rotateKeys {
+		return rc, false, nil
+	}
+
+	name := keyPairName(rc)
+
+	keyPairCacheMu.Lock()
+	cached, ok := keyPairCache[name]
+	keyPairCacheMu.Unlock()
+	if ok {
+		// Another call site already (re)generated this key pair this run;
+		// reuse it without rotating again, since this call didn't do any
+		// new work.
+		rc.InstanceCfg.KeyName = cached.name
+		return rc, false, nil
+	}
+
+	keyName, rotated, err := manager.EnsureKeyPair(ctx, name)
+	if err != nil {
+		return rc, false, fmt.Errorf("failed to ensure key pair: %v", err)
+	}
+
+	keyPairCacheMu.Lock()
+	keyPairCache[name] = keyPairCacheEntry{name: keyName}
+	keyPairCacheMu.Unlock()
+
+	rc.InstanceCfg.KeyName = keyName
+	return rc, rotated, nil
+}
+
+// regionController scopes instance lookups to a single region's VMClient, so
+// an instance tagged "Name: web" in us-east-1 is never confused with one of
+// the same name in eu-west-1.
+type regionController struct {
+	region string
+	client cloud.VMClient
+	rc     regionConfig
+}
+
+func newRegionController(rc regionConfig, client cloud.VMClient) *regionController {
+	return &regionController{region: rc.Region, client: client, rc: rc}
+}
+
+// getExistingInstances returns this region's non-terminated instances with
+// the configured Name tag.
+func (c *regionController) getExistingInstances(ctx context.Context) ([]cloud.Instance, error) {
+	return c.client.DescribeInstancesByTag(ctx, c.rc.InstanceCfg.Name)
+}
+
+// findExistingInstanceWithSameConfig returns an existing instance in this
+// region matching instance type, AMI, key name, subnet and security groups,
+// or nil if none matches.
+func (c *regionController) findExistingInstanceWithSameConfig(ctx context.Context) (*cloud.Instance, error) {
+	instances, err := c.getExistingInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instances: %v", err)
+	}
+
+	for _, instance := range instances {
+		if c.matchesConfig(instance) {
+			instance := instance
+			return &instance, nil
+		}
+	}
+
+	return nil, nil // No matching instance found
+}
+
+// matchesConfig reports whether instance was launched with the instance
+// type, AMI, key name, subnet and security groups this controller's config
+// currently calls for. An instance that doesn't match has drifted, whether
+// because the config changed (a new AMI, a rotated key pair) or because it
+// was launched some other way.
+func (c *regionController) matchesConfig(instance cloud.Instance) bool {
+	if instance.InstanceType != c.rc.InstanceCfg.InstanceType {
+		return false
+	}
+	if instance.ImageID != c.rc.InstanceCfg.AMI {
+		return false
+	}
+	if instance.KeyName != c.rc.InstanceCfg.KeyName {
+		return false
+	}
+	if instance.SubnetID != c.rc.SubnetID {
+		return false
+	}
+	if len(instance.SecurityGroupIDs) != len(c.rc.InstanceCfg.VPCSecurityGroupIDs) {
+		return false
+	}
+	for _, securityGroup := range instance.SecurityGroupIDs {
+		found := false
+		for _, configGroup := range c.rc.InstanceCfg.VPCSecurityGroupIDs {
+			if securityGroup == configGroup {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionByDrift splits instances into those matching c's current config
+// and those that have drifted from it.
+func (c *regionController) partitionByDrift(instances []cloud.Instance) (matching, drifted []cloud.Instance) {
+	for _, instance := range instances {
+		if c.matchesConfig(instance) {
+			matching = append(matching, instance)
+		} else {
+			drifted = append(drifted, instance)
+		}
+	}
+	return matching, drifted
+}
+
+// regionResult summarizes what happened (or went wrong) reconciling a
+// single region.
+type regionResult struct {
+	Region     string
+	Desired    int
+	Found      int
+	Created    int
+	Terminated int
+	Err        error
+}
+
+// reconcileRegions reconciles every region concurrently, bounded by
+// maxConcurrentRegions, and returns one result per region in the same order
+// as regionConfigs. A failure in one region does not stop the others.
+func reconcileRegions(ctx context.Context, regionConfigs []regionConfig, amiResolver *cloud.AMIResolver, rotateKeys bool) []regionResult {
+	results := make([]regionResult, len(regionConfigs))
+
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentRegions)
+
+	for i, rc := range regionConfigs {
+		i, rc := i, rc
+		g.Go(func() error {
+			results[i] = reconcileRegion(ctx, rc, amiResolver, rotateKeys)
+			return nil
+		})
+	}
+	_ = g.Wait() // errors are carried per-region in results, not returned here
+
+	return results
+}
+
+// reconcileRegion builds an AWS client scoped to rc.Region, terminates any
+// existing instances that have drifted from rc's current config, and
+// converges the remaining count to rc.InstanceCfg.DesiredCount.
+func reconcileRegion(ctx context.Context, rc regionConfig, amiResolver *cloud.AMIResolver, rotateKeys bool) regionResult {
+	result := regionResult{Region: rc.Region, Desired: rc.InstanceCfg.DesiredCount}
+
+	rc, err := resolveAMI(ctx, rc, amiResolver)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(rc.Region))
+	if err != nil {
+		result.Err = fmt.Errorf("failed to load AWS config: %v", err)
+		return result
+	}
+
+	keyManager := cloud.NewKeyManager(ec2.NewFromConfig(awsCfg), keyDir, rotateKeys)
+	rc, keysRotated, err := ensureKeyPair(ctx, rc, keyManager, rotateKeys)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	client := cloud.NewAWSVMClient(ec2.NewFromConfig(awsCfg))
+	quota := cloud.NewQuotaChecker(awsCfg)
+	controller := newRegionController(rc, client)
+
+	existingInstances, err := controller.getExistingInstances(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to check existing instances: %v", err)
+		return result
+	}
+	result.Found = len(existingInstances)
+
+	matching, drifted := controller.partitionByDrift(existingInstances)
+	if keysRotated {
+		// keyPairName is deterministic, so a rotated key pair keeps the same
+		// KeyName and matchesConfig can't see that its material changed;
+		// every existing instance is stale regardless of what it matched on.
+		drifted = append(drifted, matching...)
+		matching = nil
+	}
+	if len(drifted) > 0 {
+		// These no longer match rc's config (commonly: a key rotation
+		// replaced the key pair's material, or the AMI/instance type
+		// changed), so they won't be reused; terminate them and let the
+		// create loop below relaunch them to match the current config.
+		ids := make([]string, 0, len(drifted))
+		for _, instance := range drifted {
+			ids = append(ids, instance.ID)
+		}
+		if err := client.TerminateInstances(ctx, ids); err != nil {
+			result.Err = fmt.Errorf("failed to terminate drifted instances: %v", err)
+			return result
+		}
+		result.Terminated += len(ids)
+		existingInstances = matching
+		result.Found = len(matching)
+	}
+
+	if len(existingInstances) == rc.InstanceCfg.DesiredCount {
+		return result
+	}
+
+	instancesToCreate := rc.InstanceCfg.DesiredCount - len(existingInstances)
+
+	if instancesToCreate > 0 {
+		if err := quota.CheckCapacity(ctx, rc.Region, rc.InstanceCfg.InstanceType, instancesToCreate, existingInstances); err != nil {
+			result.Err = fmt.Errorf("service-quota preflight failed: %v", err)
+			return result
+		}
+
+		for i := 0; i < instancesToCreate; i++ {
+			if err := createEC2InstanceWithRetry(ctx, client, rc.InstanceCfg, rc.SubnetID, rc.InstanceCfg.Name); err != nil {
+				result.Err = fmt.Errorf("failed to create EC2 instance: %v", err)
+				return result
+			}
+			result.Created++
+		}
+	} else {
+		instancesToTerminate := -instancesToCreate
+		if err := terminateExcessInstances(ctx, client, existingInstances, instancesToTerminate); err != nil {
+			result.Err = fmt.Errorf("failed to terminate EC2 instances: %v", err)
+			return result
+		}
+		result.Terminated = instancesToTerminate
+	}
+
+	return result
+}
+
+// printRegionSummary reports what happened in every region, in a stable
+// order, followed by a one-line total.
+func printRegionSummary(results []regionResult) {
+	sorted := make([]regionResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Region < sorted[j].Region })
+
+	var totalCreated, totalTerminated, failures int
+	for _, result := range sorted {
+		if result.Err != nil {
+			failures++
+			fmt.Printf("[%s] FAILED: %v\n", result.Region, result.Err)
+			continue
+		}
+		fmt.Printf("[%s] found=%d desired=%d created=%d terminated=%d\n",
+			result.Region, result.Found, result.Desired, result.Created, result.Terminated)
+		totalCreated += result.Created
+		totalTerminated += result.Terminated
+	}
+
+	fmt.Printf("Reconciled %d region(s): %d created, %d terminated, %d failed.\n",
+		len(sorted), totalCreated, totalTerminated, failures)
+	if failures > 0 {
+		log.Printf("%d region(s) failed to reconcile; see FAILED lines above", failures)
+	}
+}
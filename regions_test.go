@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Kedesai/on-cloud/internal/cloud"
+)
+
+func testRegionConfig() regionConfig {
+	return regionConfig{
+		Region:   "us-east-1",
+		SubnetID: "subnet-1",
+		InstanceCfg: EC2InstanceConfig{
+			Name:                "web",
+			InstanceType:        "t3.micro",
+			AMI:                 "ami-123",
+			KeyName:             "key-1",
+			VPCSecurityGroupIDs: []string{"sg-1", "sg-2"},
+			DesiredCount:        2,
+		},
+	}
+}
+
+func TestCreateAndTerminateAgainstFakeClient(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testRegionConfig()
+	controller := newRegionController(rc, client)
+	ctx := context.Background()
+
+	for i := 0; i < rc.InstanceCfg.DesiredCount; i++ {
+		if err := createEC2Instance(ctx, client, rc.InstanceCfg, rc.SubnetID, rc.InstanceCfg.Name); err != nil {
+			t.Fatalf("createEC2Instance: %v", err)
+		}
+	}
+
+	existing, err := controller.getExistingInstances(ctx)
+	if err != nil {
+		t.Fatalf("getExistingInstances: %v", err)
+	}
+	if len(existing) != rc.InstanceCfg.DesiredCount {
+		t.Fatalf("getExistingInstances = %d instances, want %d", len(existing), rc.InstanceCfg.DesiredCount)
+	}
+
+	if err := terminateExcessInstances(ctx, client, existing, 1); err != nil {
+		t.Fatalf("terminateExcessInstances: %v", err)
+	}
+
+	remaining, err := controller.getExistingInstances(ctx)
+	if err != nil {
+		t.Fatalf("getExistingInstances: %v", err)
+	}
+	if len(remaining) != rc.InstanceCfg.DesiredCount-1 {
+		t.Fatalf("getExistingInstances after terminate = %d instances, want %d", len(remaining), rc.InstanceCfg.DesiredCount-1)
+	}
+}
+
+func TestFindExistingInstanceWithSameConfig(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testRegionConfig()
+	controller := newRegionController(rc, client)
+	ctx := context.Background()
+
+	if err := createEC2Instance(ctx, client, rc.InstanceCfg, rc.SubnetID, rc.InstanceCfg.Name); err != nil {
+		t.Fatalf("createEC2Instance: %v", err)
+	}
+
+	match, err := controller.findExistingInstanceWithSameConfig(ctx)
+	if err != nil {
+		t.Fatalf("findExistingInstanceWithSameConfig: %v", err)
+	}
+	if match == nil {
+		t.Fatal("findExistingInstanceWithSameConfig = nil, want a match")
+	}
+}
+
+func TestFindExistingInstanceWithSameConfigDetectsDrift(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testRegionConfig()
+	controller := newRegionController(rc, client)
+	ctx := context.Background()
+
+	driftedCfg := rc.InstanceCfg
+	driftedCfg.AMI = "ami-999" // a newer AMI than what's actually running
+	if err := createEC2Instance(ctx, client, driftedCfg, rc.SubnetID, driftedCfg.Name); err != nil {
+		t.Fatalf("createEC2Instance: %v", err)
+	}
+
+	match, err := controller.findExistingInstanceWithSameConfig(ctx)
+	if err != nil {
+		t.Fatalf("findExistingInstanceWithSameConfig: %v", err)
+	}
+	if match != nil {
+		t.Fatalf("findExistingInstanceWithSameConfig = %+v, want nil for a drifted instance", match)
+	}
+}
+
+func TestPartitionByDriftSeparatesMatchingFromDrifted(t *testing.T) {
+	client := cloud.NewFakeVMClient()
+	rc := testRegionConfig()
+	controller := newRegionController(rc, client)
+	ctx := context.Background()
+
+	if err := createEC2Instance(ctx, client, rc.InstanceCfg, rc.SubnetID, rc.InstanceCfg.Name); err != nil {
+		t.Fatalf("createEC2Instance: %v", err)
+	}
+	driftedCfg := rc.InstanceCfg
+	driftedCfg.KeyName = "old-key" // simulates an instance launched under a since-rotated key pair
+	if err := createEC2Instance(ctx, client, driftedCfg, rc.SubnetID, driftedCfg.Name); err != nil {
+		t.Fatalf("createEC2Instance: %v", err)
+	}
+
+	existing, err := controller.getExistingInstances(ctx)
+	if err != nil {
+		t.Fatalf("getExistingInstances: %v", err)
+	}
+
+	matching, drifted := controller.partitionByDrift(existing)
+	if len(matching) != 1 {
+		t.Fatalf("partitionByDrift matching = %+v, want exactly 1", matching)
+	}
+	if len(drifted) != 1 {
+		t.Fatalf("partitionByDrift drifted = %+v, want exactly 1", drifted)
+	}
+	if drifted[0].KeyName != "old-key" {
+		t.Fatalf("partitionByDrift drifted = %+v, want the instance with KeyName %q", drifted, "old-key")
+	}
+}
+
+// fakeKeyEnsurer is an in-memory keyEnsurer that counts how many times
+// EnsureKeyPair actually ran, so tests can assert ensureKeyPair's
+// memoization without a real EC2 client.
+type fakeKeyEnsurer struct {
+	calls int
+}
+
+func (f *fakeKeyEnsurer) EnsureKeyPair(ctx context.Context, name string) (string, bool, error) {
+	f.calls++
+	return name, true, nil
+}
+
+func TestEnsureKeyPairMemoizesAcrossCallSites(t *testing.T) {
+	rc := regionConfig{
+		Region: "us-east-1",
+		InstanceCfg: EC2InstanceConfig{
+			Name:         "ensure-key-pair-memo-test",
+			InstanceType: "t3.micro",
+			AMI:          "ami-memo-test",
+		},
+	}
+	manager := &fakeKeyEnsurer{}
+	ctx := context.Background()
+
+	// keyPairCache is memoized for the lifetime of the process, so clear any
+	// entry a previous test run (or a previous -count iteration) left behind
+	// under this same deterministic name.
+	keyPairCacheMu.Lock()
+	delete(keyPairCache, keyPairName(rc))
+	keyPairCacheMu.Unlock()
+
+	// Simulates mainHandler's one-shot reconcile calling ensureKeyPair...
+	first, rotated, err := ensureKeyPair(ctx, rc, manager, true)
+	if err != nil {
+		t.Fatalf("ensureKeyPair (first call): %v", err)
+	}
+	if !rotated {
+		t.Error("first call: rotated = false, want true")
+	}
+
+	// ...followed by the MTD loop independently resolving the same region
+	// config and calling ensureKeyPair again, in the same process.
+	second, rotated, err := ensureKeyPair(ctx, rc, manager, true)
+	if err != nil {
+		t.Fatalf("ensureKeyPair (second call): %v", err)
+	}
+	if rotated {
+		t.Error("second call: rotated = true, want false (should reuse the first call's key)")
+	}
+	if second.InstanceCfg.KeyName != first.InstanceCfg.KeyName {
+		t.Fatalf("KeyName = %q on second call, want %q (same as first call)", second.InstanceCfg.KeyName, first.InstanceCfg.KeyName)
+	}
+	if manager.calls != 1 {
+		t.Errorf("underlying EnsureKeyPair calls = %d, want 1 (memoized)", manager.calls)
+	}
+}
+
+func testMainConfig() *MainConfig {
+	cfg := &MainConfig{
+		Provider: "aws",
+		Regions:  []string{"us-east-1", "eu-west-1"},
+		SubnetID: "subnet-top-level",
+	}
+	cfg.Resources.EC2Instance = EC2InstanceConfig{
+		Name:                "web",
+		InstanceType:        "t3.micro",
+		AMI:                 "ami-top-level",
+		VPCSecurityGroupIDs: []string{"sg-top-level"},
+		DesiredCount:        2,
+	}
+	return cfg
+}
+
+func TestResolveRegionConfigsAppliesRegionOverrides(t *testing.T) {
+	cfg := testMainConfig()
+	cfg.RegionOverrides = map[string]RegionOverride{
+		"eu-west-1": {
+			SubnetID:            "subnet-eu",
+			VPCSecurityGroupIDs: []string{"sg-eu-1", "sg-eu-2"},
+			AMI:                 "ami-eu",
+		},
+	}
+
+	configs, err := resolveRegionConfigs(cfg)
+	if err != nil {
+		t.Fatalf("resolveRegionConfigs: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("resolveRegionConfigs returned %d configs, want 2", len(configs))
+	}
+
+	var eu regionConfig
+	for _, rc := range configs {
+		if rc.Region == "eu-west-1" {
+			eu = rc
+		}
+	}
+	if eu.SubnetID != "subnet-eu" {
+		t.Errorf("eu-west-1 SubnetID = %q, want %q", eu.SubnetID, "subnet-eu")
+	}
+	if eu.InstanceCfg.AMI != "ami-eu" {
+		t.Errorf("eu-west-1 AMI = %q, want %q", eu.InstanceCfg.AMI, "ami-eu")
+	}
+	if len(eu.InstanceCfg.VPCSecurityGroupIDs) != 2 || eu.InstanceCfg.VPCSecurityGroupIDs[0] != "sg-eu-1" {
+		t.Errorf("eu-west-1 VPCSecurityGroupIDs = %v, want [sg-eu-1 sg-eu-2]", eu.InstanceCfg.VPCSecurityGroupIDs)
+	}
+}
+
+func TestResolveRegionConfigsFallsBackToTopLevelWithoutOverride(t *testing.T) {
+	cfg := testMainConfig()
+	cfg.RegionOverrides = map[string]RegionOverride{
+		"eu-west-1": {SubnetID: "subnet-eu"},
+	}
+
+	configs, err := resolveRegionConfigs(cfg)
+	if err != nil {
+		t.Fatalf("resolveRegionConfigs: %v", err)
+	}
+
+	var east regionConfig
+	for _, rc := range configs {
+		if rc.Region == "us-east-1" {
+			east = rc
+		}
+	}
+	if east.SubnetID != "subnet-top-level" {
+		t.Errorf("us-east-1 SubnetID = %q, want the top-level %q (no override present)", east.SubnetID, "subnet-top-level")
+	}
+	if east.InstanceCfg.AMI != "ami-top-level" {
+		t.Errorf("us-east-1 AMI = %q, want the top-level %q (no override present)", east.InstanceCfg.AMI, "ami-top-level")
+	}
+	if len(east.InstanceCfg.VPCSecurityGroupIDs) != 1 || east.InstanceCfg.VPCSecurityGroupIDs[0] != "sg-top-level" {
+		t.Errorf("us-east-1 VPCSecurityGroupIDs = %v, want the top-level [sg-top-level]", east.InstanceCfg.VPCSecurityGroupIDs)
+	}
+}
+
+func TestResolveRegionConfigsRequiresAMIOrOSFamily(t *testing.T) {
+	cfg := testMainConfig()
+	cfg.Regions = []string{"us-east-1"}
+	cfg.Resources.EC2Instance.AMI = ""
+	cfg.Resources.EC2Instance.OSFamily = ""
+
+	if _, err := resolveRegionConfigs(cfg); err == nil {
+		t.Fatal("resolveRegionConfigs = nil error, want one for the missing AMI/OSFamily")
+	} else if !strings.Contains(err.Error(), "us-east-1") {
+		t.Errorf("resolveRegionConfigs error = %q, want it to name region %q", err, "us-east-1")
+	}
+}
+
+func TestResolveRegionConfigsRequiresSubnetID(t *testing.T) {
+	cfg := testMainConfig()
+	cfg.Regions = []string{"us-east-1"}
+	cfg.SubnetID = ""
+
+	if _, err := resolveRegionConfigs(cfg); err == nil {
+		t.Fatal("resolveRegionConfigs = nil error, want one for the missing subnet ID")
+	} else if !strings.Contains(err.Error(), "us-east-1") {
+		t.Errorf("resolveRegionConfigs error = %q, want it to name region %q", err, "us-east-1")
+	}
+}
+
+func TestResolveRegionConfigsRequiresVPCSecurityGroupIDs(t *testing.T) {
+	cfg := testMainConfig()
+	cfg.Regions = []string{"us-east-1"}
+	cfg.Resources.EC2Instance.VPCSecurityGroupIDs = nil
+
+	if _, err := resolveRegionConfigs(cfg); err == nil {
+		t.Fatal("resolveRegionConfigs = nil error, want one for the missing VPC security group IDs")
+	} else if !strings.Contains(err.Error(), "us-east-1") {
+		t.Errorf("resolveRegionConfigs error = %q, want it to name region %q", err, "us-east-1")
+	}
+}
+
+// captureStdout redirects os.Stdout for the duration of fn and returns
+// everything written to it, so printRegionSummary's output can be asserted
+// on without relying on fmt.Println's return value.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	original := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = original }()
+
+	fn()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing stdout pipe: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatalf("reading stdout pipe: %v", err)
+	}
+	return buf.String()
+}
+
+func TestPrintRegionSummaryOrdersRegionsAndTotals(t *testing.T) {
+	results := []regionResult{
+		{Region: "us-west-2", Desired: 2, Found: 1, Created: 1},
+		{Region: "eu-west-1", Desired: 1, Found: 1},
+		{Region: "us-east-1", Err: fmt.Errorf("boom")},
+	}
+
+	output := captureStdout(t, func() { printRegionSummary(results) })
+
+	euIdx := strings.Index(output, "[eu-west-1]")
+	usWestIdx := strings.Index(output, "[us-west-2]")
+	usEastIdx := strings.Index(output, "[us-east-1] FAILED: boom")
+	if euIdx == -1 || usWestIdx == -1 || usEastIdx == -1 {
+		t.Fatalf("printRegionSummary output missing expected lines:\n%s", output)
+	}
+	if !(euIdx < usEastIdx && usEastIdx < usWestIdx) {
+		t.Fatalf("printRegionSummary output not sorted by region name:\n%s", output)
+	}
+	if !strings.Contains(output, "Reconciled 3 region(s): 1 created, 0 terminated, 1 failed.") {
+		t.Fatalf("printRegionSummary totals line missing or wrong:\n%s", output)
+	}
+}